@@ -0,0 +1,157 @@
+package tikv
+
+import (
+	"container/list"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// Options configures the optional read-through cache that NewWithOptions
+// places in front of the TiKV-backed store.
+type Options struct {
+	// CacheBytes bounds the approximate in-memory footprint of cached
+	// values (e.g. 64 * 1024 * 1024 for "64MB"). Zero/negative disables
+	// the byte budget.
+	CacheBytes int64
+	// CacheEntries additionally bounds the number of cached paths,
+	// independent of their size. Zero/negative disables this budget.
+	CacheEntries int
+}
+
+// pathCache is a read-through LRU cache keyed by encoded data path,
+// modeled on go-git's plumbing/cache: a doubly-linked list orders entries
+// by recency, and insertion evicts from the back until both the byte and
+// entry budgets are satisfied.
+type pathCache struct {
+	mu sync.Mutex
+
+	maxBytes   int64
+	maxEntries int
+	curBytes   int64
+
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key   string
+	value interface{}
+	// version is the start-TS of the transaction that populated this
+	// entry. Since a read-only transaction is pinned (via SnapshotTS) to
+	// its own start-TS, it never observes a commit with a higher
+	// version, so version is a safe upper bound on when the cached value
+	// actually became current. A later reader whose own start-TS is
+	// lower than version must not be served this entry: it would be
+	// observing a value that (for all this cache can prove) may only
+	// exist because of a write that committed after the reader's own
+	// snapshot was taken.
+	version uint64
+	size    int64
+}
+
+func newPathCache(opts Options) *pathCache {
+	return &pathCache{
+		maxBytes:   opts.CacheBytes,
+		maxEntries: opts.CacheEntries,
+		ll:         list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+// get returns the cached value at key, if it exists and is valid as of
+// asOf (the caller's own transaction start-TS). Relying on invalidate
+// alone is not enough: a write transaction whose start-TS is earlier
+// than asOf can still commit, and thus populate or overwrite this entry
+// via a later reader, after asOf's transaction began — in which case
+// asOf must not see it yet, even though no invalidation for the path it
+// lives at has happened since. Rejecting any entry with a higher
+// version than asOf closes that gap; invalidate (driven by local
+// commits and the Watch feed) remains what evicts entries once they are
+// actually superseded.
+func (c *pathCache) get(key string, asOf uint64) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*cacheEntry)
+	if entry.version > asOf {
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *pathCache) set(key string, value interface{}, version uint64) {
+	size := approxSize(value)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		old := el.Value.(*cacheEntry)
+		c.curBytes += size - old.size
+		el.Value = &cacheEntry{key: key, value: value, version: version, size: size}
+		c.ll.MoveToFront(el)
+	} else {
+		el := c.ll.PushFront(&cacheEntry{key: key, value: value, version: version, size: size})
+		c.items[key] = el
+		c.curBytes += size
+	}
+
+	c.evict()
+}
+
+// invalidate drops every cached entry at, beneath or above the given
+// encoded path prefix, e.g. after a Commit writes to that path; an
+// ancestor's cached value contains the written path, so it goes too.
+func (c *pathCache) invalidate(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.items {
+		if key == prefix || strings.HasPrefix(key, prefix+"/") || strings.HasPrefix(prefix, key+"/") {
+			c.removeLocked(el)
+		}
+	}
+}
+
+func (c *pathCache) evict() {
+	for c.overBudget() {
+		back := c.ll.Back()
+		if back == nil {
+			return
+		}
+		c.removeLocked(back)
+	}
+}
+
+func (c *pathCache) overBudget() bool {
+	if c.maxBytes > 0 && c.curBytes > c.maxBytes {
+		return true
+	}
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		return true
+	}
+	return false
+}
+
+func (c *pathCache) removeLocked(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	c.ll.Remove(el)
+	delete(c.items, entry.key)
+	c.curBytes -= entry.size
+}
+
+func approxSize(value interface{}) int64 {
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return int64(len(bs))
+}
@@ -0,0 +1,100 @@
+package tikv
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/pingcap/tidb/kv"
+)
+
+// Keys under the data prefix encode the JSON document tree so that
+// lexicographic order on TiKV matches path order. Each path segment is
+// percent-escaped so that a literal "/" inside a key (e.g. an object key
+// containing a slash) can never be confused with a path separator, and
+// every key carries one of the following suffixes identifying what kind
+// of node it terminates:
+//
+//	data/<seg>/.../<seg>\x00v  -> JSON-encoded scalar leaf
+//	data/<seg>/.../<seg>\x00o  -> object container marker
+//	data/<seg>/.../<seg>\x00a  -> array container marker
+//
+// Array indices are zero-padded (arrayIndexWidth digits) so that a
+// prefix scan over an array's children returns them in index order.
+const (
+	dataPrefix = "data"
+
+	leafSuffix = "\x00v"
+	objSuffix  = "\x00o"
+	arrSuffix  = "\x00a"
+
+	arrayIndexWidth = 10
+)
+
+func encodePathSegment(s string) string {
+	s = strings.ReplaceAll(s, "%", "%25")
+	s = strings.ReplaceAll(s, "/", "%2F")
+	return s
+}
+
+func decodePathSegment(s string) string {
+	s = strings.ReplaceAll(s, "%2F", "/")
+	s = strings.ReplaceAll(s, "%25", "%")
+	return s
+}
+
+func encodeArrayIndex(i int) string {
+	s := strconv.Itoa(i)
+	if len(s) >= arrayIndexWidth {
+		return s
+	}
+	return strings.Repeat("0", arrayIndexWidth-len(s)) + s
+}
+
+// dataKey returns the key prefix that identifies path, not including the
+// leaf/container suffix.
+func dataKey(path storage.Path) kv.Key {
+	var b strings.Builder
+	b.WriteString(dataPrefix)
+	for _, seg := range path {
+		b.WriteByte('/')
+		b.WriteString(encodePathSegment(seg))
+	}
+	return kv.Key(b.String())
+}
+
+func suffixedKey(key kv.Key, suffix string) kv.Key {
+	out := make(kv.Key, 0, len(key)+len(suffix))
+	out = append(out, key...)
+	out = append(out, suffix...)
+	return out
+}
+
+func childPath(dir storage.Path, seg string) storage.Path {
+	child := make(storage.Path, len(dir)+1)
+	copy(child, dir)
+	child[len(dir)] = seg
+	return child
+}
+
+// childPrefix returns the bounded [start, end) range that a prefix scan
+// must use to enumerate the immediate and transitive children of key.
+func childPrefix(key kv.Key) (kv.Key, kv.Key) {
+	start := suffixedKey(key, "/")
+	return start, start.PrefixNext()
+}
+
+// nextSegment returns the path segment immediately following prefix in
+// encoded, i.e. the first "directory" component of a descendant key.
+func nextSegment(encoded kv.Key, prefix kv.Key) string {
+	rest := string(encoded[len(prefix):])
+	if idx := strings.IndexByte(rest, '/'); idx >= 0 {
+		return rest[:idx]
+	}
+	// No further '/': rest is "<seg><suffix>" for a direct leaf/container
+	// child. Suffixes are always exactly two bytes (NUL + type byte).
+	if len(rest) >= 2 {
+		return rest[:len(rest)-2]
+	}
+	return rest
+}
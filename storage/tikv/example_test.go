@@ -8,7 +8,7 @@ import (
 
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/tikv"
-	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/mockstore"
 )
 
 func Example_Read() {
@@ -50,7 +50,12 @@ func Example_Write() {
 		// Handle error.
 	}
 
-	var kvstore kv.Storage // initialize a TiKV Storage instance
+	// A real deployment would dial an actual TiKV cluster here; the mock
+	// store gives the example something to run against.
+	kvstore, err := mockstore.NewMockTikvStore()
+	if err != nil {
+		// Handle error.
+	}
 
 	// Instantiate the TiKV store.
 	store, err := tikv.NewFromObject(kvstore, data)
@@ -0,0 +1,79 @@
+package tikv
+
+import (
+	"context"
+	"strings"
+
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/pingcap/tidb/kv"
+)
+
+// IndexExtractFunc computes the secondary-index keys that value at path
+// should be indexed under. It must be pure (the same path/value always
+// yields the same keys) since Write diffs a call's result against the
+// pre-image to find rows to delete. value is nil when the path does not
+// exist (e.g. the pre-image of a fresh Add), so extract must handle that
+// case, typically by returning no keys.
+type IndexExtractFunc func(path storage.Path, value interface{}) [][]byte
+
+// Index registers a secondary index under name. On every subsequent Write,
+// the store maintains idx/<name>/<extracted-key>/<data-path> rows in the
+// same TiKV transaction as the data mutation, so the index stays
+// consistent under snapshot isolation. Registering a name that is already
+// in use replaces its extract function; existing rows are not
+// backfilled or dropped.
+func (s *Store) Index(name string, extract IndexExtractFunc) {
+	s.indexes[name] = extract
+}
+
+// LookupIndex returns the data paths that name's extract function has
+// associated with key, via a single bounded prefix scan.
+func (s *Store) LookupIndex(ctx context.Context, txn storage.Transaction, name string, key []byte) ([]storage.Path, error) {
+
+	underlying, err := s.underlyingTxn(txn)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := suffixedKey(indexKey(name, key, nil), "/")
+	end := prefix.PrefixNext()
+
+	iter, err := underlying.tikvtxn.Iter(prefix, end)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var paths []storage.Path
+	for iter.Valid() {
+		rest := string(iter.Key()[len(prefix):])
+		segs := strings.Split(rest, "/")
+		path := make(storage.Path, len(segs))
+		for i, seg := range segs {
+			path[i] = decodePathSegment(seg)
+		}
+		paths = append(paths, path)
+
+		if err := iter.Next(); err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+// indexKey encodes the idx/<name>/<key>/<path...> row for key at path. A
+// nil path yields just the idx/<name>/<key> prefix, used by LookupIndex to
+// scan every path registered under key.
+func indexKey(name string, key []byte, path storage.Path) kv.Key {
+	var b strings.Builder
+	b.WriteString("idx/")
+	b.WriteString(encodePathSegment(name))
+	b.WriteByte('/')
+	b.WriteString(encodePathSegment(string(key)))
+	for _, seg := range path {
+		b.WriteByte('/')
+		b.WriteString(encodePathSegment(seg))
+	}
+	return kv.Key(b.String())
+}
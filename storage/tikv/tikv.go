@@ -6,6 +6,7 @@ import (
 	"container/list"
 	"context"
 	"fmt"
+	"log"
 	"strconv"
 
 	"github.com/open-policy-agent/opa/storage"
@@ -14,13 +15,32 @@ import (
 )
 
 func New(s kv.Storage) (storage.Store, error) {
-	return &store{
+	return &Store{
 		store:    s,
 		txn:      nil,
 		triggers: map[*handle]storage.TriggerConfig{},
+		indexes:  map[string]IndexExtractFunc{},
 	}, nil
 }
 
+// NewWithOptions is like New but additionally fronts the store with a
+// bounded read-through cache, sized the way the edge blobstore is sized
+// via its DSN (e.g. Options{CacheBytes: 64 * 1024 * 1024}).
+func NewWithOptions(s kv.Storage, opts Options) (storage.Store, error) {
+	st, err := New(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CacheBytes > 0 || opts.CacheEntries > 0 {
+		concrete := st.(*Store)
+		concrete.cache = newPathCache(opts)
+		concrete.startCacheInvalidation()
+	}
+
+	return st, nil
+}
+
 // NewFromObject returns a new TiKV store from the supplied data object.
 func NewFromObject(s kv.Storage, data map[string]interface{}) (storage.Store, error) {
 	store, err := New(s)
@@ -46,30 +66,63 @@ func NewFromObject(s kv.Storage, data map[string]interface{}) (storage.Store, er
 	return store, nil
 }
 
-type store struct {
-	store    kv.Storage
-	txn      *transaction
-	triggers map[*handle]storage.TriggerConfig
+type Store struct {
+	store       kv.Storage
+	txn         *transaction
+	triggers    map[*handle]storage.TriggerConfig
+	cache       *pathCache         // nil unless constructed via NewWithOptions
+	cacheCancel context.CancelFunc // stops the Watch-driven cache invalidation below
+	indexes     map[string]IndexExtractFunc
 }
 
 type handle struct {
-	store *store
+	store *Store
 }
 
-func (s *store) NewTransaction(ctx context.Context, params ...storage.TransactionParams) (storage.Transaction, error) {
+func (s *Store) NewTransaction(ctx context.Context, params ...storage.TransactionParams) (storage.Transaction, error) {
+
+	var p storage.TransactionParams
+	if len(params) > 0 {
+		p = params[0]
+	}
+
+	// Ask the PD timestamp oracle for a start-TS up front so that ID()
+	// reflects the same snapshot the transaction reads/writes against,
+	// regardless of how long the caller holds onto it.
+	ver, err := s.store.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
 
-	txn, err := s.store.Begin()
+	tikvtxn, err := s.store.Begin()
 	if err != nil {
 		return nil, err
 	}
 
+	if !p.Write {
+		// Pin the transaction to a snapshot at its start-TS so that a
+		// Commit racing in from another OPA node on the same cluster
+		// can't be observed mid-evaluation.
+		tikvtxn.SetOption(kv.IsolationLevel, kv.SI)
+		tikvtxn.SetOption(kv.SnapshotTS, ver.Ver)
+	}
+
+	startTS := ver.Ver
+	if p.Write {
+		startTS = tikvtxn.StartTS()
+	}
+
 	return &transaction{
-		tikvtxn: txn,
-		updates: list.New(),
+		tikvtxn:       tikvtxn,
+		startTS:       startTS,
+		write:         p.Write,
+		updates:       list.New(),
+		policyUpdates: list.New(),
+		indexes:       s.indexes,
 	}, nil
 }
 
-func (s *store) ListPolicies(ctx context.Context, txn storage.Transaction) ([]string, error) {
+func (s *Store) ListPolicies(ctx context.Context, txn storage.Transaction) ([]string, error) {
 
 	underlying, err := s.underlyingTxn(txn)
 	if err != nil {
@@ -79,7 +132,7 @@ func (s *store) ListPolicies(ctx context.Context, txn storage.Transaction) ([]st
 	return underlying.ListPolicies(ctx)
 }
 
-func (s *store) GetPolicy(ctx context.Context, txn storage.Transaction, id string) ([]byte, error) {
+func (s *Store) GetPolicy(ctx context.Context, txn storage.Transaction, id string) ([]byte, error) {
 
 	underlying, err := s.underlyingTxn(txn)
 	if err != nil {
@@ -89,7 +142,7 @@ func (s *store) GetPolicy(ctx context.Context, txn storage.Transaction, id strin
 	return underlying.GetPolicy(ctx, id)
 }
 
-func (s *store) UpsertPolicy(ctx context.Context, txn storage.Transaction, id string, bs []byte) error {
+func (s *Store) UpsertPolicy(ctx context.Context, txn storage.Transaction, id string, bs []byte) error {
 
 	underlying, err := s.underlyingTxn(txn)
 	if err != nil {
@@ -99,7 +152,7 @@ func (s *store) UpsertPolicy(ctx context.Context, txn storage.Transaction, id st
 	return underlying.UpsertPolicy(ctx, id, bs)
 }
 
-func (s *store) DeletePolicy(ctx context.Context, txn storage.Transaction, id string) error {
+func (s *Store) DeletePolicy(ctx context.Context, txn storage.Transaction, id string) error {
 
 	underlying, err := s.underlyingTxn(txn)
 	if err != nil {
@@ -109,19 +162,36 @@ func (s *store) DeletePolicy(ctx context.Context, txn storage.Transaction, id st
 	return underlying.DeletePolicy(ctx, id)
 }
 
-func (s *store) Read(ctx context.Context, txn storage.Transaction, path storage.Path) (interface{}, error) {
+func (s *Store) Read(ctx context.Context, txn storage.Transaction, path storage.Path) (interface{}, error) {
 
 	underlying, err := s.underlyingTxn(txn)
 	if err != nil {
 		return nil, err
 	}
 
-	_ = underlying
+	// A write transaction must never read through the cache: its own
+	// uncommitted writes are visible to itself before they are visible
+	// to anyone else, and caching that pre-commit view would leak it to
+	// every other transaction that happens to read the same path next.
+	if s.cache != nil && !underlying.write {
+		key := string(dataKey(path))
+		if v, ok := s.cache.get(key, underlying.startTS); ok {
+			return v, nil
+		}
+
+		v, err := underlying.Read(ctx, path)
+		if err != nil {
+			return nil, err
+		}
 
-	return nil, fmt.Errorf("Not Implemented")
+		s.cache.set(key, v, underlying.startTS)
+		return v, nil
+	}
+
+	return underlying.Read(ctx, path)
 }
 
-func (s *store) Write(ctx context.Context, txn storage.Transaction, op storage.PatchOp, path storage.Path, value interface{}) error {
+func (s *Store) Write(ctx context.Context, txn storage.Transaction, op storage.PatchOp, path storage.Path, value interface{}) error {
 
 	underlying, err := s.underlyingTxn(txn)
 	if err != nil {
@@ -133,38 +203,110 @@ func (s *store) Write(ctx context.Context, txn storage.Transaction, op storage.P
 		return err
 	}
 
-	return underlying.Write(op, path, *ptrval)
+	return underlying.Write(ctx, op, path, *ptrval)
 }
 
-func (s *store) Commit(ctx context.Context, txn storage.Transaction) error {
+func (s *Store) Commit(ctx context.Context, txn storage.Transaction) error {
 
 	underlying, err := s.underlyingTxn(txn)
 	if err != nil {
 		return err
 	}
 
+	event := underlying.commitEvent()
+
 	if err := underlying.Commit(ctx); err != nil {
-		s.Abort(ctx, txn)
+		// underlying.Commit has already run the tikv txn's own close/
+		// cleanup on failure (e.g. a write conflict), so there is nothing
+		// left to roll back here; err is returned as is to a caller that
+		// may want to retry a retryable error like a write conflict.
 		return err
 	}
 
-	var event storage.TriggerEvent
+	// The commit log is appended in its own transaction, after the data
+	// transaction has already committed. Folding it into the same 2PC
+	// write set would tie its key (keyed by this transaction's start-TS)
+	// to start order rather than commit order, which Watch cannot
+	// tolerate: a transaction that starts first can still commit last. A
+	// best-effort log append here trades strict atomicity with the data
+	// commit (a crash between the two leaves the commit un-logged) for a
+	// log that Watch can actually rely on for ordering. Since the data
+	// transaction has already committed durably at this point, a failure
+	// here must not be returned as a commit failure — the caller would
+	// treat an applied write as failed and could retry it, double
+	// applying e.g. an array "-" append — nor skip the local cache
+	// invalidation or triggers below.
+	if err := s.appendCommitLog(ctx, event); err != nil {
+		log.Printf("tikv: commit log append failed, Watch may miss this commit: %v", err)
+	}
+
+	if s.cache != nil {
+		for curr := underlying.updates.Front(); curr != nil; curr = curr.Next() {
+			u := curr.Value.(*update)
+			s.cache.invalidate(string(dataKey(u.path)))
+		}
+	}
+
 	s.runOnCommitTriggers(ctx, txn, event)
 
 	return nil
 }
 
-func (s *store) Abort(ctx context.Context, txn storage.Transaction) {
+func (s *Store) Abort(ctx context.Context, txn storage.Transaction) {
 
 	underlying, err := s.underlyingTxn(txn)
 	if err != nil {
 		panic(err)
 	}
 
-	_ = underlying // use underlying
+	// Roll back so a half-prewritten optimistic txn releases its
+	// membuffer and locks immediately instead of waiting out their TTL.
+	// Callers commonly `defer store.Abort(ctx, txn)` right after opening
+	// a transaction and then Commit it explicitly, so Abort routinely
+	// runs against a txn that already closed itself, successfully or
+	// not (e.g. a write conflict in Commit) — kv.ErrInvalidTxn here just
+	// means there was nothing left to roll back, not a real failure.
+	if err := underlying.tikvtxn.Rollback(); err != nil && !kv.ErrInvalidTxn.Equal(err) {
+		panic(err)
+	}
+}
+
+// startCacheInvalidation subscribes the cache to every commit against
+// this cluster, including ones made by other OPA nodes, via the same
+// commit log Watch tails. Store.Commit already invalidates synchronously
+// for this node's own commits; this closes the gap for everyone else's.
+func (s *Store) startCacheInvalidation() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cacheCancel = cancel
+
+	ch, err := s.Watch(ctx, storage.Path{})
+	if err != nil {
+		// The cache still serves correctly for a single-node deployment
+		// (Store.Commit invalidates directly); it just can't be trusted
+		// to reflect other nodes' commits until Watch is available.
+		cancel()
+		return
+	}
+
+	go func() {
+		for event := range ch {
+			for _, de := range event.Data {
+				s.cache.invalidate(string(dataKey(de.Path)))
+			}
+		}
+	}()
+}
+
+// Close stops the background cache invalidation started by
+// NewWithOptions. It is a no-op if no cache was configured.
+func (s *Store) Close() {
+	if s.cacheCancel != nil {
+		s.cacheCancel()
+	}
 }
 
-func (s *store) Register(ctx context.Context, txn storage.Transaction, config storage.TriggerConfig) (storage.TriggerHandle, error) {
+func (s *Store) Register(ctx context.Context, txn storage.Transaction, config storage.TriggerConfig) (storage.TriggerHandle, error) {
 
 	h := &handle{s}
 	s.triggers[h] = config
@@ -175,7 +317,7 @@ func (h *handle) Unregister(ctx context.Context, txn storage.Transaction) {
 	delete(h.store.triggers, h)
 }
 
-func (s *store) underlyingTxn(txn storage.Transaction) (*transaction, error) {
+func (s *Store) underlyingTxn(txn storage.Transaction) (*transaction, error) {
 	underlying, ok := txn.(*transaction)
 	if !ok {
 		return nil, &storage.Error{
@@ -187,12 +329,37 @@ func (s *store) underlyingTxn(txn storage.Transaction) (*transaction, error) {
 	return underlying, nil
 }
 
-func (s *store) runOnCommitTriggers(ctx context.Context, txn storage.Transaction, event storage.TriggerEvent) {
+func (s *Store) runOnCommitTriggers(ctx context.Context, txn storage.Transaction, event storage.TriggerEvent) {
 	for _, t := range s.triggers {
 		t.OnCommit(ctx, txn, event)
 	}
 }
 
+// translateCommitErr maps a TiKV commit failure onto the storage.Error
+// codes callers already know how to handle. Write conflicts come back
+// from the 2PC protocol as kv.ErrWriteConflict and are safe to retry from
+// scratch (the transaction holds no partial state); a duplicate-key
+// conflict against data written out-of-band is not.
+func translateCommitErr(err error) error {
+	switch {
+	case kv.ErrKeyExists.Equal(err):
+		return &storage.Error{
+			Code:    storage.InvalidPatchErr,
+			Message: err.Error(),
+		}
+	case kv.ErrWriteConflict.Equal(err), kv.ErrWriteConflictInTiDB.Equal(err):
+		return &storage.Error{
+			Code:    storage.InternalErr,
+			Message: fmt.Sprintf("retryable: %v", err),
+		}
+	default:
+		return &storage.Error{
+			Code:    storage.InternalErr,
+			Message: err.Error(),
+		}
+	}
+}
+
 var doesNotExistMsg = "document does not exist"
 var rootMustBeObjectMsg = "root must be object"
 var rootCannotBeRemovedMsg = "root cannot be removed"
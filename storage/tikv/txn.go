@@ -3,24 +3,77 @@ package tikv
 import (
 	"container/list"
 	"context"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/util"
 	"github.com/pingcap/tidb/kv"
 )
 
 type transaction struct {
-	tikvtxn kv.Transaction
-	updates *list.List
+	tikvtxn       kv.Transaction
+	startTS       uint64 // PD timestamp oracle value the transaction started at
+	write         bool
+	updates       *list.List // *update, one per data Write call
+	policyUpdates *list.List // *policyUpdate, one per policy Upsert/Delete call
+	indexes       map[string]IndexExtractFunc
 }
 
+// policyUpdate records a single policy mutation so that Commit can surface
+// it to OnCommit triggers and the commit log.
+type policyUpdate struct {
+	id      string
+	data    []byte
+	removed bool
+}
+
+// ID returns the transaction's PD-assigned start timestamp. Two
+// transactions opened against the same snapshot (e.g. a write and the
+// read-only transactions it forks for concurrent evaluations) never
+// share an ID, since each call to NewTransaction asks PD for a fresh TS.
 func (txn *transaction) ID() uint64 {
-	return 1
+	return txn.startTS
 }
 
-func (tx *transaction) Commit(ctx context.Context) error {
-	return fmt.Errorf("Not Implemented")
+func (txn *transaction) Commit(ctx context.Context) error {
+	if !txn.write {
+		return nil
+	}
+	if err := txn.tikvtxn.Commit(ctx); err != nil {
+		return translateCommitErr(err)
+	}
+	return nil
+}
+
+// commitEvent translates the writes recorded on this transaction into a
+// storage.TriggerEvent, oldest first, for OnCommit triggers and the
+// commit log.
+func (txn *transaction) commitEvent() storage.TriggerEvent {
+
+	var event storage.TriggerEvent
+
+	for curr := txn.updates.Back(); curr != nil; curr = curr.Prev() {
+		u := curr.Value.(*update)
+		event.Data = append(event.Data, storage.DataEvent{
+			Path:    u.path,
+			Data:    u.value,
+			Removed: u.remove,
+		})
+	}
+
+	for curr := txn.policyUpdates.Back(); curr != nil; curr = curr.Prev() {
+		p := curr.Value.(*policyUpdate)
+		event.Policy = append(event.Policy, storage.PolicyEvent{
+			ID:      p.id,
+			Data:    p.data,
+			Removed: p.removed,
+		})
+	}
+
+	return event
 }
 
 func (txn *transaction) ListPolicies(ctx context.Context) ([]string, error) {
@@ -61,47 +114,441 @@ func (txn *transaction) GetPolicy(ctx context.Context, id string) ([]byte, error
 func (txn *transaction) UpsertPolicy(ctx context.Context, id string, bs []byte) error {
 
 	keyStr := fmt.Sprintf("policies/%s", id)
-	return txn.tikvtxn.Set(kv.Key([]byte(keyStr)), bs)
+	if err := txn.tikvtxn.Set(kv.Key([]byte(keyStr)), bs); err != nil {
+		return err
+	}
+
+	txn.policyUpdates.PushFront(&policyUpdate{id: id, data: bs})
+	return nil
 }
 
 func (txn *transaction) DeletePolicy(ctx context.Context, id string) error {
 
 	keyStr := fmt.Sprintf("policies/%s", id)
-	return txn.tikvtxn.Delete(kv.Key([]byte(keyStr)))
+	if err := txn.tikvtxn.Delete(kv.Key([]byte(keyStr))); err != nil {
+		return err
+	}
+
+	txn.policyUpdates.PushFront(&policyUpdate{id: id, removed: true})
+	return nil
 }
 
-func (txn *transaction) Write(op storage.PatchOp, path storage.Path, value interface{}) error {
-	if len(path) == 0 {
-		return txn.updateRoot(underlying, op, *ptrval)
+// Read reconstructs the value stored at path by walking the encoded data
+// tree. Scalars are stored under a single leaf key; objects and arrays are
+// stored as a container marker plus one key per child, so a read at an
+// intermediate path issues one bounded prefix scan per level rather than
+// fetching an entire serialized subtree.
+func (txn *transaction) Read(ctx context.Context, path storage.Path) (interface{}, error) {
+	return txn.readAt(ctx, path, path)
+}
+
+// readAt behaves like Read but reports any not-found error against
+// errPath rather than path. Write uses this when it looks up path's
+// parent directory, so that a missing or out-of-range intermediate
+// segment is still reported against the full path the caller actually
+// tried to patch, not just the directory that turned out to be the
+// problem.
+func (txn *transaction) readAt(ctx context.Context, errPath, path storage.Path) (interface{}, error) {
+
+	key, err := txn.resolveKey(ctx, errPath, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return txn.readKey(ctx, errPath, path, key)
+}
+
+func (txn *transaction) readKey(ctx context.Context, errPath, path storage.Path, key kv.Key) (interface{}, error) {
+
+	if bs, err := txn.tikvtxn.Get(ctx, suffixedKey(key, leafSuffix)); err == nil {
+		var v interface{}
+		if err := util.UnmarshalJSON(bs, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	} else if err != kv.ErrNotExist {
+		return nil, err
+	}
+
+	if _, err := txn.tikvtxn.Get(ctx, suffixedKey(key, objSuffix)); err == nil {
+		return txn.readObject(ctx, path, key)
+	} else if err != kv.ErrNotExist {
+		return nil, err
+	}
+
+	if _, err := txn.tikvtxn.Get(ctx, suffixedKey(key, arrSuffix)); err == nil {
+		return txn.readArray(ctx, path, key)
+	} else if err != kv.ErrNotExist {
+		return nil, err
+	}
+
+	return nil, notFoundError(errPath)
+}
+
+func (txn *transaction) readObject(ctx context.Context, path storage.Path, key kv.Key) (map[string]interface{}, error) {
+
+	segs, err := txn.childSegments(ctx, key)
+	if err != nil {
+		return nil, err
 	}
 
-	for curr := txn.updates.Front(); curr != nil; {
+	obj := make(map[string]interface{}, len(segs))
+	for _, seg := range segs {
+		name := decodePathSegment(seg)
+		childP := childPath(path, name)
+		v, err := txn.readKey(ctx, childP, childP, suffixedKey(key, "/"+seg))
+		if err != nil {
+			return nil, err
+		}
+		obj[name] = v
+	}
+
+	return obj, nil
+}
 
-		update := curr.Value.(*update)
+func (txn *transaction) readArray(ctx context.Context, path storage.Path, key kv.Key) ([]interface{}, error) {
 
-		_ = update
+	// Indices are zero-padded so childSegments already returns them in
+	// ascending order.
+	segs, err := txn.childSegments(ctx, key)
+	if err != nil {
+		return nil, err
 	}
 
-	update, err := newUpdate(op, path, 0, value)
+	arr := make([]interface{}, len(segs))
+	for i, seg := range segs {
+		childP := childPath(path, seg)
+		v, err := txn.readKey(ctx, childP, childP, suffixedKey(key, "/"+seg))
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+
+	return arr, nil
+}
+
+// childSegments returns the distinct immediate child segments found under
+// key, via a single bounded Iter(prefix, prefix.PrefixNext()) scan.
+func (txn *transaction) childSegments(ctx context.Context, key kv.Key) ([]string, error) {
+
+	start, end := childPrefix(key)
+
+	iter, err := txn.tikvtxn.Iter(start, end)
 	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var segs []string
+	seen := map[string]bool{}
+	for iter.Valid() {
+		seg := nextSegment(iter.Key(), start)
+		if !seen[seg] {
+			seen[seg] = true
+			segs = append(segs, seg)
+		}
+		if err := iter.Next(); err != nil {
+			return nil, err
+		}
+	}
+
+	return segs, nil
+}
+
+// resolveKey translates path into its encoded TiKV key prefix, reporting
+// any array-index error against errPath. Because array elements are
+// stored zero-padded, each segment must be checked in turn against the
+// container it is about to descend into, and bounds-checked against
+// that container's current length so an out-of-range intermediate
+// segment is rejected here rather than surfacing later as a generic
+// not-found once the (never written) encoded key fails to resolve.
+func (txn *transaction) resolveKey(ctx context.Context, errPath, path storage.Path) (kv.Key, error) {
+
+	key := kv.Key(dataPrefix)
+
+	for _, seg := range path {
+
+		if _, err := txn.tikvtxn.Get(ctx, suffixedKey(key, arrSuffix)); err == nil {
+			idx, convErr := strconv.Atoi(seg)
+			if convErr != nil {
+				return nil, notFoundErrorHint(errPath, arrayIndexTypeMsg)
+			}
+
+			segs, err := txn.childSegments(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if idx < 0 || idx >= len(segs) {
+				return nil, notFoundErrorHint(errPath, outOfRangeMsg)
+			}
+
+			seg = encodeArrayIndex(idx)
+		} else if err != kv.ErrNotExist {
+			return nil, err
+		}
+
+		key = suffixedKey(key, "/"+encodePathSegment(seg))
+	}
+
+	return key, nil
+}
+
+func (txn *transaction) Write(ctx context.Context, op storage.PatchOp, path storage.Path, value interface{}) error {
+
+	// The pre-image is only needed to diff against registered indexes;
+	// skip the extra subtree read entirely when none are registered.
+	var oldValue interface{}
+	if len(txn.indexes) > 0 {
+		v, err := txn.Read(ctx, path)
+		if err != nil && !storage.IsNotFound(err) {
+			return err
+		}
+		oldValue = v
+	}
+
+	if len(path) == 0 {
+		if err := txn.writeRoot(ctx, op, value); err != nil {
+			return err
+		}
+	} else {
+
+		dir := path[:len(path)-1]
+		key := path[len(path)-1]
+
+		// Report a missing/out-of-range dir against the full path the
+		// caller is patching, not just the directory that failed.
+		parent, err := txn.readAt(ctx, path, dir)
+		if err != nil {
+			return err
+		}
+
+		switch parent := parent.(type) {
+		case map[string]interface{}:
+			if err := txn.writeObject(ctx, dir, parent, op, key, value); err != nil {
+				return err
+			}
+		case []interface{}:
+			if err := txn.writeArray(ctx, dir, parent, op, key, value); err != nil {
+				return err
+			}
+		default:
+			return notFoundError(path)
+		}
+	}
+
+	if err := txn.updateIndexes(ctx, path, oldValue); err != nil {
 		return err
 	}
 
-	txn.updates.PushFront(update)
+	txn.updates.PushFront(&update{path: path, remove: op == storage.RemoveOp, value: value})
+	return nil
+}
+
+// updateIndexes keeps every registered secondary index consistent with the
+// write that was just applied at path. It diffs the pre-image (oldValue,
+// captured before the write) against the post-image to find stale index
+// rows, and maintains everything through the same tikvtxn as the data
+// mutation so an index can never observe a commit its data didn't.
+func (txn *transaction) updateIndexes(ctx context.Context, path storage.Path, oldValue interface{}) error {
+
+	if len(txn.indexes) == 0 {
+		return nil
+	}
+
+	newValue, err := txn.Read(ctx, path)
+	if err != nil && !storage.IsNotFound(err) {
+		return err
+	}
+
+	for name, extract := range txn.indexes {
+
+		newKeys := extract(path, newValue)
+		newSet := make(map[string]bool, len(newKeys))
+
+		for _, k := range newKeys {
+			newSet[string(k)] = true
+			if err := txn.tikvtxn.Set(indexKey(name, k, path), []byte{}); err != nil {
+				return err
+			}
+		}
+
+		for _, k := range extract(path, oldValue) {
+			if newSet[string(k)] {
+				continue
+			}
+			if err := txn.tikvtxn.Delete(indexKey(name, k, path)); err != nil && err != kv.ErrNotExist {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
-func (txn *transaction) updateRoot(op storage.PatchOp, value interface{}) error {
+func (txn *transaction) writeRoot(ctx context.Context, op storage.PatchOp, value interface{}) error {
 
 	if op == storage.RemoveOp {
 		return invalidPatchError(rootCannotBeRemovedMsg)
 	}
 
-	// the root '/' path must be a valid JSON object
-	if _, ok := value.(map[string]interface{}); !ok {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
 		return invalidPatchError(rootMustBeObjectMsg)
 	}
 
+	return txn.writeValue(ctx, storage.Path{}, obj)
+}
+
+func (txn *transaction) writeObject(ctx context.Context, dir storage.Path, obj map[string]interface{}, op storage.PatchOp, key string, value interface{}) error {
+
+	path := childPath(dir, key)
+	_, exists := obj[key]
+
+	switch op {
+	case storage.RemoveOp:
+		if !exists {
+			return notFoundError(path)
+		}
+		return txn.deleteSubtree(path)
+	case storage.ReplaceOp:
+		if !exists {
+			return notFoundError(path)
+		}
+	}
+
+	return txn.writeValue(ctx, path, value)
+}
+
+func (txn *transaction) writeArray(ctx context.Context, dir storage.Path, arr []interface{}, op storage.PatchOp, key string, value interface{}) error {
+
+	if key == "-" {
+		if op != storage.AddOp {
+			return invalidPatchError("%v: invalid patch path", childPath(dir, key).String())
+		}
+		return txn.rewriteArray(ctx, dir, append(arr, value))
+	}
+
+	idx, err := validateArrayIndex(arr, key, childPath(dir, key))
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case storage.RemoveOp:
+		next := make([]interface{}, 0, len(arr)-1)
+		next = append(next, arr[:idx]...)
+		next = append(next, arr[idx+1:]...)
+		return txn.rewriteArray(ctx, dir, next)
+	case storage.AddOp:
+		next := make([]interface{}, 0, len(arr)+1)
+		next = append(next, arr[:idx]...)
+		next = append(next, value)
+		next = append(next, arr[idx:]...)
+		return txn.rewriteArray(ctx, dir, next)
+	default: // storage.ReplaceOp
+		next := make([]interface{}, len(arr))
+		copy(next, arr)
+		next[idx] = value
+		return txn.rewriteArray(ctx, dir, next)
+	}
+}
+
+// rewriteArray replaces the array stored at dir wholesale. Inserting or
+// removing an element shifts the zero-padded index of every following
+// element, so there is no cheaper way to keep the encoding contiguous.
+func (txn *transaction) rewriteArray(ctx context.Context, dir storage.Path, arr []interface{}) error {
+	return txn.writeValue(ctx, dir, arr)
+}
+
+// writeValue overwrites whatever is stored at path (if anything) with value.
+func (txn *transaction) writeValue(ctx context.Context, path storage.Path, value interface{}) error {
+	// path may name array elements by their plain index (e.g. /h/0/1);
+	// write under the zero-padded segments resolveKey maps them to.
+	key, err := txn.resolveKey(ctx, path, path)
+	if err != nil {
+		return err
+	}
+	stored := storage.Path{}
+	for _, seg := range strings.Split(string(key), "/")[1:] {
+		stored = append(stored, decodePathSegment(seg))
+	}
+	if err := txn.deleteSubtree(stored); err != nil {
+		return err
+	}
+	return txn.putValue(stored, value)
+}
+
+func (txn *transaction) putValue(path storage.Path, value interface{}) error {
+
+	key := dataKey(path)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if err := txn.tikvtxn.Set(suffixedKey(key, objSuffix), []byte{}); err != nil {
+			return err
+		}
+		for k, child := range v {
+			if err := txn.putValue(childPath(path, k), child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		if err := txn.tikvtxn.Set(suffixedKey(key, arrSuffix), []byte{}); err != nil {
+			return err
+		}
+		for i, child := range v {
+			if err := txn.putValue(childPath(path, encodeArrayIndex(i)), child); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return txn.tikvtxn.Set(suffixedKey(key, leafSuffix), bs)
+	}
+}
+
+// deleteSubtree removes any leaf/container marker at path along with every
+// descendant key beneath it.
+func (txn *transaction) deleteSubtree(path storage.Path) error {
+
+	key := dataKey(path)
+
+	for _, suffix := range []string{leafSuffix, objSuffix, arrSuffix} {
+		if err := txn.tikvtxn.Delete(suffixedKey(key, suffix)); err != nil && err != kv.ErrNotExist {
+			return err
+		}
+	}
+
+	start, end := childPrefix(key)
+
+	iter, err := txn.tikvtxn.Iter(start, end)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	var stale []kv.Key
+	for iter.Valid() {
+		k := make(kv.Key, len(iter.Key()))
+		copy(k, iter.Key())
+		stale = append(stale, k)
+		if err := iter.Next(); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range stale {
+		if err := txn.tikvtxn.Delete(k); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
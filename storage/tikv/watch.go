@@ -0,0 +1,271 @@
+package tikv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/util"
+	"github.com/pingcap/tidb/kv"
+)
+
+// commitLogPrefix namespaces the append-only log of committed
+// storage.TriggerEvents that Watch tails. Entries are keyed by a
+// dedicated commit-log sequence number (see commitLogSeqKey), not by the
+// committing transaction's start-TS: start-TS order is not commit order
+// (a transaction that started earlier can still commit later than one
+// that started after it), so keying by start-TS would let Watch's
+// cursor advance past a slower transaction's entry and drop it. The
+// sequence counter is itself only incremented after the data transaction
+// has already committed, so entries are written in true completion
+// order and a cursor can never be advanced past one still to come.
+const commitLogPrefix = "commit-log/"
+
+// commitLogSeqKey holds the last assigned commit-log sequence number.
+// Bumping it happens in its own small transaction per commit, so
+// concurrent committers serialize on it via a normal write conflict.
+var commitLogSeqKey = kv.Key("commit-log-seq")
+
+const commitLogSeqWidth = 20 // len(strconv.FormatUint(math.MaxUint64, 10))
+
+// commitLogMaxRetries bounds how many times appendCommitLog retries after
+// losing the race to bump commitLogSeqKey.
+const commitLogMaxRetries = 10
+
+// watchPollInterval is how often Watch scans for new commit-log entries.
+// A native TiKV CDC/change-feed client would push these instead of
+// requiring a poll, but that requires operators to run a separate cdc
+// process; the polling fallback covers single-binary deployments and can
+// be swapped out later without changing the Watch API. Note this bounds
+// remote-commit cache invalidation (see startCacheInvalidation in
+// tikv.go) to this same interval: a read against the cache on one node
+// can observe up to watchPollInterval of staleness following a commit on
+// another. Callers that cannot tolerate that window should read with a
+// store that was constructed via New rather than NewWithOptions.
+const watchPollInterval = 500 * time.Millisecond
+
+func commitLogKey(seq uint64) kv.Key {
+	return kv.Key(fmt.Sprintf("%s%0*d", commitLogPrefix, commitLogSeqWidth, seq))
+}
+
+func parseCommitLogKey(key kv.Key) (uint64, error) {
+	s := strings.TrimPrefix(string(key), commitLogPrefix)
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// appendCommitLog assigns event the next commit-log sequence number and
+// writes it, retrying if another node's commit races it for the same
+// sequence number. It runs after the data transaction has already
+// committed, so two commits are never ordered using this function alone;
+// it only needs to agree with itself on an order, which the conflict on
+// commitLogSeqKey guarantees.
+func (s *Store) appendCommitLog(ctx context.Context, event storage.TriggerEvent) error {
+	if len(event.Data) == 0 && len(event.Policy) == 0 {
+		return nil
+	}
+
+	bs, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt <= commitLogMaxRetries; attempt++ {
+
+		logTxn, err := s.store.Begin()
+		if err != nil {
+			return err
+		}
+
+		seq, err := nextCommitLogSeq(ctx, logTxn)
+		if err != nil {
+			logTxn.Rollback()
+			return err
+		}
+
+		if err := logTxn.Set(commitLogKey(seq), bs); err != nil {
+			logTxn.Rollback()
+			return err
+		}
+		if err := logTxn.Set(commitLogSeqKey, []byte(strconv.FormatUint(seq, 10))); err != nil {
+			logTxn.Rollback()
+			return err
+		}
+
+		err = logTxn.Commit(ctx)
+		if err == nil {
+			return nil
+		}
+		if !kv.ErrWriteConflict.Equal(err) {
+			return err
+		}
+		// Another commit grabbed this sequence number first; retry with a
+		// freshly read counter.
+	}
+
+	return fmt.Errorf("commit log: exceeded %d retries assigning a sequence number", commitLogMaxRetries)
+}
+
+func nextCommitLogSeq(ctx context.Context, txn kv.Transaction) (uint64, error) {
+	bs, err := txn.Get(ctx, commitLogSeqKey)
+	if err != nil {
+		if err == kv.ErrNotExist {
+			return 1, nil
+		}
+		return 0, err
+	}
+
+	cur, err := strconv.ParseUint(string(bs), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return cur + 1, nil
+}
+
+// Watch tails commits against this TiKV cluster, including ones made by
+// other OPA instances, so multi-node deployments can fan out invalidation
+// without a separate bus. The returned channel is closed when ctx is
+// canceled.
+func (s *Store) Watch(ctx context.Context, prefix storage.Path) (<-chan storage.TriggerEvent, error) {
+
+	after, err := s.currentCommitLogSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan storage.TriggerEvent)
+
+	go s.pollCommitLog(ctx, after, prefix, ch)
+
+	return ch, nil
+}
+
+// currentCommitLogSeq returns the sequence number of the last commit-log
+// entry written so far, so a fresh Watch only observes commits that
+// happen after it starts.
+func (s *Store) currentCommitLogSeq(ctx context.Context) (uint64, error) {
+
+	snapshotTxn, err := s.store.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer snapshotTxn.Rollback()
+
+	bs, err := snapshotTxn.Get(ctx, commitLogSeqKey)
+	if err != nil {
+		if err == kv.ErrNotExist {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return strconv.ParseUint(string(bs), 10, 64)
+}
+
+func (s *Store) pollCommitLog(ctx context.Context, after uint64, prefix storage.Path, ch chan<- storage.TriggerEvent) {
+
+	defer close(ch)
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		events, last, err := s.readCommitLog(ctx, after)
+		if err != nil {
+			// Transient TiKV error (e.g. a leader election); retry on
+			// the next tick rather than tearing down the watch.
+			continue
+		}
+		after = last
+
+		for _, event := range events {
+			if !eventTouchesPrefix(event, prefix) {
+				continue
+			}
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// readCommitLog returns every commit-log entry strictly after seq, and the
+// highest sequence number observed (or seq unchanged if nothing new was
+// found).
+func (s *Store) readCommitLog(ctx context.Context, seq uint64) ([]storage.TriggerEvent, uint64, error) {
+
+	snapshotTxn, err := s.store.Begin()
+	if err != nil {
+		return nil, seq, err
+	}
+	defer snapshotTxn.Rollback()
+
+	start := commitLogKey(seq + 1)
+	end := kv.Key(commitLogPrefix).PrefixNext()
+
+	iter, err := snapshotTxn.Iter(start, end)
+	if err != nil {
+		return nil, seq, err
+	}
+	defer iter.Close()
+
+	var events []storage.TriggerEvent
+	last := seq
+
+	for iter.Valid() {
+		var event storage.TriggerEvent
+		if err := util.UnmarshalJSON(iter.Value(), &event); err != nil {
+			return nil, seq, err
+		}
+		events = append(events, event)
+
+		if entrySeq, err := parseCommitLogKey(iter.Key()); err == nil && entrySeq > last {
+			last = entrySeq
+		}
+
+		if err := iter.Next(); err != nil {
+			return nil, seq, err
+		}
+	}
+
+	return events, last, nil
+}
+
+func eventTouchesPrefix(event storage.TriggerEvent, prefix storage.Path) bool {
+	if len(prefix) == 0 {
+		// An empty prefix subscribes to the whole store; a policy-only
+		// commit has no data path to match against but must still be
+		// delivered rather than silently dropped.
+		return len(event.Data) > 0 || len(event.Policy) > 0
+	}
+	for _, de := range event.Data {
+		if pathHasPrefix(de.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func pathHasPrefix(path, prefix storage.Path) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, seg := range prefix {
+		if path[i] != seg {
+			return false
+		}
+	}
+	return true
+}